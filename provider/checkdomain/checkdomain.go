@@ -1,16 +1,29 @@
 package checkdomain
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/matthiasng/dns-provider-api/provider"
+	"github.com/matthiasng/dns-provider-api/provider/internal/cname"
+	"github.com/matthiasng/dns-provider-api/provider/internal/limiter"
+
+	"github.com/go-acme/lego/v3/challenge"
+	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/platform/config/env"
 )
 
+func init() {
+	provider.Register("checkdomain", func() (challenge.Provider, error) {
+		return NewDNSProvider()
+	})
+}
+
 // Environment variables names.
 const (
 	envNamespace = "CHECKDOMAIN_"
@@ -22,6 +35,12 @@ const (
 	EnvPropagationTimeout = envNamespace + "PROPAGATION_TIMEOUT"
 	EnvPollingInterval    = envNamespace + "POLLING_INTERVAL"
 	EnvHTTPTimeout        = envNamespace + "HTTP_TIMEOUT"
+
+	EnvSequenceInterval  = envNamespace + "SEQUENCE_INTERVAL"
+	EnvRequestsPerMinute = envNamespace + "REQUESTS_PER_MINUTE"
+	EnvMaxConcurrency    = envNamespace + "MAX_CONCURRENCY"
+
+	EnvFollowCNAME = envNamespace + "FOLLOW_CNAME"
 )
 
 const (
@@ -37,6 +56,28 @@ type Config struct {
 	PropagationTimeout time.Duration
 	PollingInterval    time.Duration
 	HTTPClient         *http.Client
+
+	// SequenceInterval is the time to wait between challenges when the
+	// ACME client solves them sequentially instead of in parallel.
+	SequenceInterval time.Duration
+
+	// RequestsPerMinute caps the number of API calls issued per minute.
+	// A value of 0 disables rate limiting.
+	RequestsPerMinute int
+
+	// MaxConcurrency caps the number of in-flight Present/CleanUp calls.
+	// A value of 0 disables the concurrency cap.
+	MaxConcurrency int
+
+	// FollowCNAME resolves fqdn's CNAME chain and solves the challenge
+	// at the terminal name instead, enabling acme-dns style delegation.
+	FollowCNAME bool
+
+	// StateStore persists the domain ID resolved for each fqdn in
+	// Present so CleanUp can reuse it even if the process restarted in
+	// between. Defaults to an in-memory store, which does not survive
+	// restarts.
+	StateStore provider.StateStore
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider
@@ -48,6 +89,11 @@ func NewDefaultConfig() *Config {
 		HTTPClient: &http.Client{
 			Timeout: env.GetOrDefaultSecond(EnvHTTPTimeout, 30*time.Second),
 		},
+		SequenceInterval:  env.GetOrDefaultSecond(EnvSequenceInterval, 0),
+		RequestsPerMinute: env.GetOrDefaultInt(EnvRequestsPerMinute, 0),
+		MaxConcurrency:    env.GetOrDefaultInt(EnvMaxConcurrency, 0),
+		FollowCNAME:       env.GetOrDefaultBool(EnvFollowCNAME, env.GetOrDefaultBool(cname.EnvGlobalFollowCNAME, false)),
+		StateStore:        provider.NewMemoryStateStore(),
 	}
 }
 
@@ -56,8 +102,8 @@ func NewDefaultConfig() *Config {
 type DNSProvider struct {
 	config *Config
 
-	domainIDMu      sync.Mutex
-	domainIDMapping map[string]int
+	stateStore provider.StateStore
+	limiter    *limiter.Limiter
 }
 
 func NewDNSProvider() (*DNSProvider, error) {
@@ -91,14 +137,32 @@ func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 		config.HTTPClient = http.DefaultClient
 	}
 
+	if config.StateStore == nil {
+		config.StateStore = provider.NewMemoryStateStore()
+	}
+
 	return &DNSProvider{
-		config:          config,
-		domainIDMapping: make(map[string]int),
+		config:     config,
+		stateStore: config.StateStore,
+		limiter:    limiter.New(config.RequestsPerMinute, config.MaxConcurrency),
 	}, nil
 }
 
 // Present creates a TXT record to fulfill the dns-01 challenge
 func (d *DNSProvider) Present(domain, token, fqdn, value string) error {
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("checkdomain: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		var err error
+		domain, fqdn, err = resolveCNAMETarget(fqdn)
+		if err != nil {
+			return fmt.Errorf("checkdomain: %w", err)
+		}
+	}
+
 	domainID, err := d.getDomainIDByName(domain)
 	if err != nil {
 		return fmt.Errorf("checkdomain: %w", err)
@@ -120,15 +184,41 @@ func (d *DNSProvider) Present(domain, token, fqdn, value string) error {
 		return fmt.Errorf("checkdomain: %w", err)
 	}
 
+	if err := d.stateStore.Set(fqdn, strconv.Itoa(domainID)); err != nil {
+		return fmt.Errorf("checkdomain: %w", err)
+	}
+
 	return nil
 }
 
 // CleanUp removes the TXT record previously created
 func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
-	domainID, err := d.getDomainIDByName(domain)
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("checkdomain: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		var err error
+		domain, fqdn, err = resolveCNAMETarget(fqdn)
+		if err != nil {
+			return fmt.Errorf("checkdomain: %w", err)
+		}
+	}
+
+	// Prefer the domain ID stored in Present; fall back to looking it up
+	// again through the API when the process restarted in between and
+	// lost any in-memory state.
+	domainID, ok, err := d.lookupDomainID(fqdn)
 	if err != nil {
 		return fmt.Errorf("checkdomain: %w", err)
 	}
+	if !ok {
+		domainID, err = d.getDomainIDByName(domain)
+		if err != nil {
+			return fmt.Errorf("checkdomain: %w", err)
+		}
+	}
 
 	err = d.checkNameservers(domainID)
 	if err != nil {
@@ -140,13 +230,53 @@ func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
 		return fmt.Errorf("checkdomain: %w", err)
 	}
 
-	d.domainIDMu.Lock()
-	delete(d.domainIDMapping, fqdn)
-	d.domainIDMu.Unlock()
+	if err := d.stateStore.Delete(fqdn); err != nil {
+		return fmt.Errorf("checkdomain: %w", err)
+	}
 
 	return nil
 }
 
+// lookupDomainID returns the domain ID stored for fqdn, if any.
+func (d *DNSProvider) lookupDomainID(fqdn string) (int, bool, error) {
+	value, ok, err := d.stateStore.Get(fqdn)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	domainID, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid stored domain ID for %s: %w", fqdn, err)
+	}
+
+	return domainID, true, nil
+}
+
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
 }
+
+// resolveCNAMETarget follows fqdn's CNAME chain and returns the terminal
+// name along with the domain owning the zone that name lives in, so the
+// challenge is solved at the delegated target rather than the original
+// domain.
+func resolveCNAMETarget(fqdn string) (domain, resolvedFqdn string, err error) {
+	resolvedFqdn, err = cname.Resolve(fqdn)
+	if err != nil {
+		return "", "", err
+	}
+
+	zone, err := dns01.FindZoneByFqdn(resolvedFqdn)
+	if err != nil {
+		return "", "", err
+	}
+
+	return dns01.UnFqdn(zone), resolvedFqdn, nil
+}
+
+// Sequential causes the ACME client to solve challenges for this
+// provider one at a time instead of in parallel, waiting the returned
+// duration between each one.
+func (d *DNSProvider) Sequential() time.Duration {
+	return d.config.SequenceInterval
+}
@@ -2,16 +2,29 @@
 package hostingde
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/matthiasng/dns-provider-api/provider"
+	"github.com/matthiasng/dns-provider-api/provider/internal/batcher"
+	"github.com/matthiasng/dns-provider-api/provider/internal/cname"
+	"github.com/matthiasng/dns-provider-api/provider/internal/limiter"
+
+	"github.com/go-acme/lego/v3/challenge"
 	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/platform/config/env"
 )
 
+func init() {
+	provider.Register("hostingde", func() (challenge.Provider, error) {
+		return NewDNSProvider()
+	})
+}
+
 // Environment variables names.
 const (
 	envNamespace = "HOSTINGDE_"
@@ -23,8 +36,24 @@ const (
 	EnvPropagationTimeout = envNamespace + "PROPAGATION_TIMEOUT"
 	EnvPollingInterval    = envNamespace + "POLLING_INTERVAL"
 	EnvHTTPTimeout        = envNamespace + "HTTP_TIMEOUT"
+
+	EnvSequenceInterval  = envNamespace + "SEQUENCE_INTERVAL"
+	EnvRequestsPerMinute = envNamespace + "REQUESTS_PER_MINUTE"
+	EnvMaxConcurrency    = envNamespace + "MAX_CONCURRENCY"
+	EnvBatchWindow       = envNamespace + "BATCH_WINDOW"
+
+	EnvFollowCNAME = envNamespace + "FOLLOW_CNAME"
 )
 
+// defaultBatchWindow is how long the provider waits for concurrent
+// Present/CleanUp calls targeting the same zone to accumulate before
+// issuing a single combined zone update, since hosting.de replaces the
+// entire zone on every update call. It defaults to 0 (batching disabled)
+// so a lone, non-concurrent renewal isn't taxed with a debounce delay it
+// gets no benefit from; operators issuing multi-SAN certificates can opt
+// in via HOSTINGDE_BATCH_WINDOW.
+const defaultBatchWindow = 0
+
 // Config is used to configure the creation of the DNSProvider
 type Config struct {
 	APIKey             string
@@ -33,6 +62,35 @@ type Config struct {
 	PollingInterval    time.Duration
 	TTL                int
 	HTTPClient         *http.Client
+
+	// SequenceInterval is the time to wait between challenges when the
+	// ACME client solves them sequentially instead of in parallel. Note
+	// that a non-zero SequenceInterval means lego never calls
+	// Present/CleanUp concurrently, so BatchWindow will rarely see more
+	// than one record to coalesce; the two are complementary fixes for
+	// "too many requests", not additive ones, and enabling both together
+	// just adds the batch delay without a coalescing benefit.
+	SequenceInterval time.Duration
+
+	// RequestsPerMinute caps the number of API calls issued per minute.
+	// A value of 0 disables rate limiting.
+	RequestsPerMinute int
+
+	// MaxConcurrency caps the number of in-flight Present/CleanUp calls.
+	// A value of 0 disables the concurrency cap.
+	MaxConcurrency int
+
+	// BatchWindow is how long concurrent Present/CleanUp calls for the
+	// same zone are buffered before being flushed as a single
+	// ZoneUpdateRequest. A value of 0 (the default) disables batching.
+	// Coalescing only helps when multiple calls are actually in flight
+	// together, so a low MaxConcurrency or a non-zero SequenceInterval
+	// will starve the batcher of anything to coalesce.
+	BatchWindow time.Duration
+
+	// FollowCNAME resolves fqdn's CNAME chain and solves the challenge
+	// at the terminal name instead, enabling acme-dns style delegation.
+	FollowCNAME bool
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider
@@ -44,6 +102,11 @@ func NewDefaultConfig() *Config {
 		HTTPClient: &http.Client{
 			Timeout: env.GetOrDefaultSecond(EnvHTTPTimeout, 30*time.Second),
 		},
+		SequenceInterval:  env.GetOrDefaultSecond(EnvSequenceInterval, 0),
+		RequestsPerMinute: env.GetOrDefaultInt(EnvRequestsPerMinute, 0),
+		MaxConcurrency:    env.GetOrDefaultInt(EnvMaxConcurrency, 0),
+		BatchWindow:       env.GetOrDefaultSecond(EnvBatchWindow, defaultBatchWindow),
+		FollowCNAME:       env.GetOrDefaultBool(EnvFollowCNAME, env.GetOrDefaultBool(cname.EnvGlobalFollowCNAME, false)),
 	}
 }
 
@@ -52,6 +115,18 @@ type DNSProvider struct {
 	config      *Config
 	recordIDs   map[string]string
 	recordIDsMu sync.Mutex
+	limiter     *limiter.Limiter
+	batcher     *batcher.Batcher
+}
+
+// zoneOp is a single record addition or deletion submitted to the
+// provider's batcher. fqdn and value identify the caller's record so
+// flushZone can report back the ID hosting.de assigned to it.
+type zoneOp struct {
+	fqdn   string
+	value  string
+	add    *DNSRecord
+	delete *DNSRecord
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for hosting.de.
@@ -84,10 +159,14 @@ func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 		return nil, errors.New("hostingde: Zone Name missing")
 	}
 
-	return &DNSProvider{
+	d := &DNSProvider{
 		config:    config,
 		recordIDs: make(map[string]string),
-	}, nil
+		limiter:   limiter.New(config.RequestsPerMinute, config.MaxConcurrency),
+	}
+	d.batcher = batcher.New(config.BatchWindow, d.flushZone)
+
+	return d, nil
 }
 
 // Timeout returns the timeout and interval to use when checking for DNS propagation.
@@ -96,67 +175,102 @@ func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
-// Present creates a TXT record to fulfill the dns-01 challenge
+// Sequential causes the ACME client to solve challenges for this
+// provider one at a time instead of in parallel, waiting the returned
+// duration between each one.
+func (d *DNSProvider) Sequential() time.Duration {
+	return d.config.SequenceInterval
+}
+
+// Present creates a TXT record to fulfill the dns-01 challenge. The
+// actual API call is coalesced with other concurrent Present/CleanUp
+// calls for the same zone by d.batcher.
 func (d *DNSProvider) Present(domain, token, fqdn, value string) error {
-	// get the ZoneConfig for that domain
-	zonesFind := ZoneConfigsFindRequest{
-		Filter: Filter{
-			Field: "zoneName",
-			Value: d.config.ZoneName,
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("hostingde: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
+		if err != nil {
+			return fmt.Errorf("hostingde: %w", err)
+		}
+		fqdn = resolved
+	}
+
+	op := zoneOp{
+		fqdn:  fqdn,
+		value: value,
+		add: &DNSRecord{
+			Type:    "TXT",
+			Name:    dns01.UnFqdn(fqdn),
+			Content: value,
+			TTL:     d.config.TTL,
 		},
-		Limit: 1,
-		Page:  1,
 	}
-	zonesFind.AuthToken = d.config.APIKey
 
-	zoneConfig, err := d.getZone(zonesFind)
-	if err != nil {
+	if err := d.batcher.Submit(d.config.ZoneName, op); err != nil {
 		return fmt.Errorf("hostingde: %w", err)
 	}
-	zoneConfig.Name = d.config.ZoneName
 
-	rec := []DNSRecord{{
-		Type:    "TXT",
-		Name:    dns01.UnFqdn(fqdn),
-		Content: value,
-		TTL:     d.config.TTL,
-	}}
+	d.recordIDsMu.Lock()
+	id := d.recordIDs[fqdn]
+	d.recordIDsMu.Unlock()
 
-	req := ZoneUpdateRequest{
-		ZoneConfig:   *zoneConfig,
-		RecordsToAdd: rec,
+	if id == "" {
+		return fmt.Errorf("hostingde: error getting ID of just created record, for domain %s", domain)
 	}
-	req.AuthToken = d.config.APIKey
 
-	resp, err := d.updateZone(req)
-	if err != nil {
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters. The
+// actual API call is coalesced with other concurrent Present/CleanUp
+// calls for the same zone by d.batcher.
+func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
+	if err := d.limiter.Wait(context.Background()); err != nil {
 		return fmt.Errorf("hostingde: %w", err)
 	}
+	defer d.limiter.Done()
 
-	for _, record := range resp.Response.Records {
-		if record.Name == dns01.UnFqdn(fqdn) && record.Content == fmt.Sprintf(`"%s"`, value) {
-			d.recordIDsMu.Lock()
-			d.recordIDs[fqdn] = record.ID
-			d.recordIDsMu.Unlock()
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
+		if err != nil {
+			return fmt.Errorf("hostingde: %w", err)
 		}
+		fqdn = resolved
 	}
 
-	if d.recordIDs[fqdn] == "" {
-		return fmt.Errorf("hostingde: error getting ID of just created record, for domain %s", domain)
+	op := zoneOp{
+		fqdn:  fqdn,
+		value: value,
+		delete: &DNSRecord{
+			Type:    "TXT",
+			Name:    dns01.UnFqdn(fqdn),
+			Content: `"` + value + `"`,
+		},
 	}
 
+	if err := d.batcher.Submit(d.config.ZoneName, op); err != nil {
+		return fmt.Errorf("hostingde: %w", err)
+	}
+
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn)
+	d.recordIDsMu.Unlock()
+
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters
-func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
-	rec := []DNSRecord{{
-		Type:    "TXT",
-		Name:    dns01.UnFqdn(fqdn),
-		Content: `"` + value + `"`,
-	}}
+// flushZone applies every zoneOp accumulated during a batch window in a
+// single ZoneUpdateRequest, since hosting.de replaces the entire zone's
+// record set on every update call. A failure to reach the API is
+// genuinely shared by the whole batch, so it is reported to every
+// caller.
+func (d *DNSProvider) flushZone(zone string, values []interface{}) []error {
+	errs := make([]error, len(values))
 
-	// get the ZoneConfig for that domain
 	zonesFind := ZoneConfigsFindRequest{
 		Filter: Filter{
 			Field: "zoneName",
@@ -169,24 +283,47 @@ func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
 
 	zoneConfig, err := d.getZone(zonesFind)
 	if err != nil {
-		return fmt.Errorf("hostingde: %w", err)
+		return fillErr(errs, fmt.Errorf("hostingde: %w", err))
 	}
 	zoneConfig.Name = d.config.ZoneName
 
-	req := ZoneUpdateRequest{
-		ZoneConfig:      *zoneConfig,
-		RecordsToDelete: rec,
-	}
+	req := ZoneUpdateRequest{ZoneConfig: *zoneConfig}
 	req.AuthToken = d.config.APIKey
 
-	// Delete record ID from map
-	d.recordIDsMu.Lock()
-	delete(d.recordIDs, fqdn)
-	d.recordIDsMu.Unlock()
+	for _, v := range values {
+		op := v.(zoneOp)
+		if op.add != nil {
+			req.RecordsToAdd = append(req.RecordsToAdd, *op.add)
+		}
+		if op.delete != nil {
+			req.RecordsToDelete = append(req.RecordsToDelete, *op.delete)
+		}
+	}
 
-	_, err = d.updateZone(req)
+	resp, err := d.updateZone(req)
 	if err != nil {
-		return fmt.Errorf("hostingde: %w", err)
+		return fillErr(errs, fmt.Errorf("hostingde: %w", err))
 	}
-	return nil
+
+	for _, record := range resp.Response.Records {
+		for _, v := range values {
+			op := v.(zoneOp)
+			if op.add != nil && record.Name == dns01.UnFqdn(op.fqdn) && record.Content == fmt.Sprintf(`"%s"`, op.value) {
+				d.recordIDsMu.Lock()
+				d.recordIDs[op.fqdn] = record.ID
+				d.recordIDsMu.Unlock()
+			}
+		}
+	}
+
+	return errs
+}
+
+// fillErr sets every entry of errs to err and returns it, for flush
+// failures that genuinely affect every record in the batch.
+func fillErr(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
 }
@@ -0,0 +1,87 @@
+// Package batcher coalesces concurrent per-zone operations into a single
+// debounced flush. It exists for DNS APIs (hosting.de, netcup) that
+// replace or diff an entire zone on every update: issuing a multi-SAN or
+// wildcard certificate otherwise triggers one whole-zone round-trip per
+// name, which is both slow and prone to races between overlapping writes.
+package batcher
+
+import (
+	"sync"
+	"time"
+)
+
+// FlushFunc applies the accumulated values for a zone in a single call.
+// It must return one error per value, in the same order as values, so a
+// failure specific to one caller's record (e.g. a lookup miss) is
+// reported only to that caller instead of failing every other caller
+// batched into the same window. Errors that genuinely affect the whole
+// batch (e.g. the API call itself failing) may be repeated across all
+// entries.
+type FlushFunc func(zone string, values []interface{}) []error
+
+// Batcher buffers values submitted for the same zone key and flushes
+// them together after a debounce window has elapsed since the first
+// submission in that window.
+type Batcher struct {
+	mu     sync.Mutex
+	window time.Duration
+	flush  FlushFunc
+	zones  map[string]*pending
+}
+
+type pending struct {
+	values  []interface{}
+	waiters []chan error
+	timer   *time.Timer
+}
+
+// New returns a Batcher that waits window before flushing each zone's
+// accumulated values via flush. A window of 0 flushes synchronously,
+// i.e. every Submit call triggers its own flush.
+func New(window time.Duration, flush FlushFunc) *Batcher {
+	return &Batcher{
+		window: window,
+		flush:  flush,
+		zones:  make(map[string]*pending),
+	}
+}
+
+// Submit adds value to the batch for zone and blocks until that batch
+// has been flushed, returning whatever error the flush produced.
+func (b *Batcher) Submit(zone string, value interface{}) error {
+	if b.window <= 0 {
+		return b.flush(zone, []interface{}{value})[0]
+	}
+
+	result := make(chan error, 1)
+
+	b.mu.Lock()
+	p, ok := b.zones[zone]
+	if !ok {
+		p = &pending{}
+		b.zones[zone] = p
+		p.timer = time.AfterFunc(b.window, func() { b.flushZone(zone) })
+	}
+	p.values = append(p.values, value)
+	p.waiters = append(p.waiters, result)
+	b.mu.Unlock()
+
+	return <-result
+}
+
+func (b *Batcher) flushZone(zone string) {
+	b.mu.Lock()
+	p, ok := b.zones[zone]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.zones, zone)
+	b.mu.Unlock()
+
+	errs := b.flush(zone, p.values)
+
+	for i, w := range p.waiters {
+		w <- errs[i]
+	}
+}
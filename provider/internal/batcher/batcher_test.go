@@ -0,0 +1,127 @@
+package batcher
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubmitSynchronousWindow(t *testing.T) {
+	var flushedZones []string
+
+	b := New(0, func(zone string, values []interface{}) []error {
+		flushedZones = append(flushedZones, zone)
+		return make([]error, len(values))
+	})
+
+	if err := b.Submit("example.com", "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Submit("example.com", "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(flushedZones) != 2 {
+		t.Errorf("window 0 should flush every Submit independently, got %d flushes", len(flushedZones))
+	}
+}
+
+func TestSubmitCoalescesConcurrentCalls(t *testing.T) {
+	var mu sync.Mutex
+	var flushes [][]interface{}
+
+	b := New(50*time.Millisecond, func(zone string, values []interface{}) []error {
+		mu.Lock()
+		flushes = append(flushes, values)
+		mu.Unlock()
+		return make([]error, len(values))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(v int) {
+			defer wg.Done()
+			if err := b.Submit("example.com", v); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(flushes) != 1 {
+		t.Fatalf("expected all 5 concurrent submissions to coalesce into 1 flush, got %d", len(flushes))
+	}
+	if len(flushes[0]) != 5 {
+		t.Errorf("expected the single flush to carry all 5 values, got %d", len(flushes[0]))
+	}
+}
+
+func TestSubmitPerItemErrorIsolation(t *testing.T) {
+	errBad := errors.New("bad value")
+
+	b := New(50*time.Millisecond, func(zone string, values []interface{}) []error {
+		errs := make([]error, len(values))
+		for i, v := range values {
+			if v == "bad" {
+				errs[i] = errBad
+			}
+		}
+		return errs
+	})
+
+	var wg sync.WaitGroup
+	results := make([]error, 3)
+	values := []string{"good", "bad", "good"}
+
+	for i, v := range values {
+		wg.Add(1)
+		go func(i int, v string) {
+			defer wg.Done()
+			results[i] = b.Submit("example.com", v)
+		}(i, v)
+	}
+	wg.Wait()
+
+	for i, v := range values {
+		if v == "bad" && !errors.Is(results[i], errBad) {
+			t.Errorf("value %q at index %d: expected errBad, got %v", v, i, results[i])
+		}
+		if v == "good" && results[i] != nil {
+			t.Errorf("value %q at index %d: expected no error, got %v", v, i, results[i])
+		}
+	}
+}
+
+func TestSubmitSeparateZonesFlushSeparately(t *testing.T) {
+	var mu sync.Mutex
+	zoneValues := make(map[string][]interface{})
+
+	b := New(50*time.Millisecond, func(zone string, values []interface{}) []error {
+		mu.Lock()
+		zoneValues[zone] = append(zoneValues[zone], values...)
+		mu.Unlock()
+		return make([]error, len(values))
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = b.Submit("a.example.com", 1)
+	}()
+	go func() {
+		defer wg.Done()
+		_ = b.Submit("b.example.com", 2)
+	}()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(zoneValues["a.example.com"]) != 1 || len(zoneValues["b.example.com"]) != 1 {
+		t.Errorf("expected each zone to be flushed independently, got %v", zoneValues)
+	}
+}
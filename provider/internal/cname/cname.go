@@ -0,0 +1,46 @@
+// Package cname resolves the terminal target of a CNAME chain. Providers
+// use it to support the acme-dns delegation pattern, where a zone's
+// production records stay at a locked-down registrar while its
+// _acme-challenge record is a CNAME pointing at a zone one of this
+// module's providers can write to.
+package cname
+
+import (
+	"fmt"
+	"net"
+)
+
+// EnvGlobalFollowCNAME is the environment variable that enables CNAME
+// following for every provider that doesn't set its own *_FOLLOW_CNAME
+// override.
+const EnvGlobalFollowCNAME = "DNS_PROVIDER_FOLLOW_CNAME"
+
+// maxChainLength bounds how many CNAME hops Resolve will follow before
+// giving up, guarding against misconfigured or looping CNAME chains.
+const maxChainLength = 10
+
+// Resolve follows the CNAME chain starting at fqdn and returns the
+// terminal name. If fqdn has no CNAME record, fqdn is returned unchanged.
+func Resolve(fqdn string) (string, error) {
+	current := fqdn
+
+	for i := 0; i < maxChainLength; i++ {
+		target, err := net.LookupCNAME(current)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve CNAME for %s: %w", current, err)
+		}
+
+		// An empty target means LookupCNAME got a NODATA response: current
+		// exists but holds no CNAME (and no A/AAAA either, which is the
+		// normal shape of an acme-dns style delegation target that only
+		// ever holds TXT challenge records). That means current is already
+		// the terminal name, same as target == current.
+		if target == "" || target == current {
+			return current, nil
+		}
+
+		current = target
+	}
+
+	return "", fmt.Errorf("cname chain starting at %s exceeds %d hops", fqdn, maxChainLength)
+}
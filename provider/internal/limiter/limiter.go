@@ -0,0 +1,113 @@
+// Package limiter provides shared per-provider rate limiting and
+// concurrency control for DNS providers whose upstream APIs enforce
+// strict per-account request quotas (e.g. netcup's JSON-RPC or INWX's
+// XML-RPC endpoints). Providers that solve multi-SAN or wildcard
+// certificates issue many concurrent Present/CleanUp calls, which can
+// trigger "too many requests" errors without this kind of throttling.
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter bounds the rate and concurrency of calls made against a
+// provider's API. It combines a token-bucket rate limiter with a
+// semaphore capping the number of in-flight requests. Either aspect
+// can be disabled independently.
+type Limiter struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	refill float64 // tokens added per second
+	last   time.Time
+
+	sem chan struct{}
+}
+
+// New returns a Limiter that allows at most requestsPerMinute requests
+// per minute and at most maxConcurrency calls in flight at once.
+// A requestsPerMinute of 0 disables rate limiting; a maxConcurrency of
+// 0 disables the concurrency cap. A Limiter with both set to 0 is a
+// no-op and safe to use.
+func New(requestsPerMinute, maxConcurrency int) *Limiter {
+	l := &Limiter{last: time.Now()}
+
+	if requestsPerMinute > 0 {
+		l.max = float64(requestsPerMinute)
+		l.tokens = l.max
+		l.refill = float64(requestsPerMinute) / 60
+	}
+
+	if maxConcurrency > 0 {
+		l.sem = make(chan struct{}, maxConcurrency)
+	}
+
+	return l
+}
+
+// Wait blocks until a request is permitted to proceed under both the
+// rate limit and the concurrency cap, or until ctx is done. On success,
+// the caller must call Done exactly once, typically via defer.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if l.max > 0 {
+		if err := l.waitForToken(ctx); err != nil {
+			if l.sem != nil {
+				<-l.sem
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Done releases the concurrency slot acquired by a successful Wait call.
+func (l *Limiter) Done() {
+	if l == nil || l.sem == nil {
+		return
+	}
+	<-l.sem
+}
+
+func (l *Limiter) waitForToken(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.refill
+		if l.tokens > l.max {
+			l.tokens = l.max
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.refill * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
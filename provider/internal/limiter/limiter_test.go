@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewNoOpWhenBothDisabled(t *testing.T) {
+	l := New(0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("Wait should never block with both limits disabled: %v", err)
+	}
+	l.Done()
+}
+
+func TestNilLimiterIsNoOp(t *testing.T) {
+	var l *Limiter
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait on a nil Limiter should be a no-op: %v", err)
+	}
+	l.Done()
+}
+
+func TestMaxConcurrencyCapsInFlightCalls(t *testing.T) {
+	l := New(0, 2)
+
+	var inFlight int32
+	var maxSeen int32
+
+	acquire := func() {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			old := atomic.LoadInt32(&maxSeen)
+			if n <= old || atomic.CompareAndSwapInt32(&maxSeen, old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		l.Done()
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		go func() {
+			acquire()
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+
+	if atomic.LoadInt32(&maxSeen) > 2 {
+		t.Errorf("expected at most 2 concurrent calls, saw %d", maxSeen)
+	}
+}
+
+func TestWaitRespectsContextCancellation(t *testing.T) {
+	l := New(0, 1)
+
+	// Hold the only concurrency slot.
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := l.Wait(ctx)
+	if err == nil {
+		t.Fatal("expected Wait to return an error once the context is done")
+	}
+}
+
+func TestRateLimitSpacesOutRequests(t *testing.T) {
+	// 60 requests per minute == 1 per second, refilled continuously, so
+	// the first request beyond the initial full bucket should need to wait.
+	l := New(60, 0)
+
+	ctx := context.Background()
+
+	// Drain the initial full bucket.
+	for i := 0; i < 60; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("unexpected error draining bucket: %v", err)
+		}
+		l.Done()
+	}
+
+	start := time.Now()
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.Done()
+	elapsed := time.Since(start)
+
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("expected the request beyond the drained bucket to wait for a refill, only waited %v", elapsed)
+	}
+}
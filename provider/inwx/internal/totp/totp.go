@@ -0,0 +1,59 @@
+// Package totp generates RFC 6238 time-based one-time passwords, used to
+// unlock INWX accounts that have two-factor authentication enabled.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	timeStep = 30 * time.Second
+	digits   = 6
+)
+
+// GenerateCode returns the current 6-digit TOTP code for the given
+// base32-encoded shared secret, following RFC 6238 with the default
+// HMAC-SHA1, 30-second time step parameters used by INWX.
+func GenerateCode(sharedSecret string, now time.Time) (string, error) {
+	key, err := decodeSecret(sharedSecret)
+	if err != nil {
+		return "", fmt.Errorf("invalid shared secret: %w", err)
+	}
+
+	counter := uint64(now.Unix()) / uint64(timeStep.Seconds())
+
+	var counterBytes [8]byte
+	binary.BigEndian.PutUint64(counterBytes[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counterBytes[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := truncated % pow10(digits)
+
+	return fmt.Sprintf("%0*d", digits, code), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimSpace(secret))
+	secret = strings.TrimRight(secret, "=")
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func pow10(n int) uint32 {
+	r := uint32(1)
+	for i := 0; i < n; i++ {
+		r *= 10
+	}
+	return r
+}
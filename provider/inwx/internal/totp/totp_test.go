@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// secret is the RFC 6238 SHA1 test vector seed, "12345678901234567890",
+// base32-encoded the way INWX's shared secrets are delivered.
+var secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	// RFC 6238 Appendix B gives these as 8-digit codes for HMAC-SHA1;
+	// GenerateCode truncates to 6 digits, so we compare against the last
+	// 6 digits of each published vector.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := GenerateCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("GenerateCode(%d) returned error: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("GenerateCode(%d) = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateCodeDifferentStepsDiffer(t *testing.T) {
+	a, err := GenerateCode(secret, time.Unix(59, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := GenerateCode(secret, time.Unix(60, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if a == b {
+		t.Errorf("codes for different 30s steps (59, 60) should differ, both were %q", a)
+	}
+}
+
+func TestGenerateCodeInvalidSecret(t *testing.T) {
+	if _, err := GenerateCode("not valid base32!!", time.Unix(59, 0)); err == nil {
+		t.Error("expected an error for an invalid base32 secret, got nil")
+	}
+}
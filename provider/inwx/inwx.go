@@ -2,27 +2,47 @@
 package inwx
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/matthiasng/dns-provider-api/provider"
+	"github.com/matthiasng/dns-provider-api/provider/internal/cname"
+	"github.com/matthiasng/dns-provider-api/provider/internal/limiter"
+	"github.com/matthiasng/dns-provider-api/provider/inwx/internal/totp"
+
+	"github.com/go-acme/lego/v3/challenge"
 	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/log"
 	"github.com/go-acme/lego/v3/platform/config/env"
 	"github.com/nrdcg/goinwx"
 )
 
+func init() {
+	provider.Register("inwx", func() (challenge.Provider, error) {
+		return NewDNSProvider()
+	})
+}
+
 // Environment variables names.
 const (
 	envNamespace = "INWX_"
 
-	EnvUsername = envNamespace + "USERNAME"
-	EnvPassword = envNamespace + "PASSWORD"
-	EnvSandbox  = envNamespace + "SANDBOX"
+	EnvUsername     = envNamespace + "USERNAME"
+	EnvPassword     = envNamespace + "PASSWORD"
+	EnvSandbox      = envNamespace + "SANDBOX"
+	EnvSharedSecret = envNamespace + "SHARED_SECRET"
 
 	EnvTTL                = envNamespace + "TTL"
 	EnvPropagationTimeout = envNamespace + "PROPAGATION_TIMEOUT"
 	EnvPollingInterval    = envNamespace + "POLLING_INTERVAL"
+
+	EnvSequenceInterval  = envNamespace + "SEQUENCE_INTERVAL"
+	EnvRequestsPerMinute = envNamespace + "REQUESTS_PER_MINUTE"
+	EnvMaxConcurrency    = envNamespace + "MAX_CONCURRENCY"
+
+	EnvFollowCNAME = envNamespace + "FOLLOW_CNAME"
 )
 
 // Config is used to configure the creation of the DNSProvider
@@ -33,6 +53,31 @@ type Config struct {
 	PropagationTimeout time.Duration
 	PollingInterval    time.Duration
 	TTL                int
+
+	// SharedSecret is the base32-encoded TOTP shared secret shown when
+	// enabling two-factor authentication on the INWX account. When set,
+	// Present and CleanUp unlock the account with a freshly computed
+	// TOTP code after each login.
+	SharedSecret string
+
+	// SequenceInterval is the time to wait between challenges when the
+	// ACME client solves them sequentially instead of in parallel. INWX's
+	// XML-RPC API enforces a strict per-account request rate, so spacing
+	// out challenges avoids "too many requests" errors on multi-SAN or
+	// wildcard certificates.
+	SequenceInterval time.Duration
+
+	// RequestsPerMinute caps the number of API calls issued per minute.
+	// A value of 0 disables rate limiting.
+	RequestsPerMinute int
+
+	// MaxConcurrency caps the number of in-flight Present/CleanUp calls.
+	// A value of 0 disables the concurrency cap.
+	MaxConcurrency int
+
+	// FollowCNAME resolves fqdn's CNAME chain and solves the challenge
+	// at the terminal name instead, enabling acme-dns style delegation.
+	FollowCNAME bool
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider
@@ -42,13 +87,18 @@ func NewDefaultConfig() *Config {
 		PropagationTimeout: env.GetOrDefaultSecond(EnvPropagationTimeout, dns01.DefaultPropagationTimeout),
 		PollingInterval:    env.GetOrDefaultSecond(EnvPollingInterval, dns01.DefaultPollingInterval),
 		Sandbox:            env.GetOrDefaultBool(EnvSandbox, false),
+		SequenceInterval:   env.GetOrDefaultSecond(EnvSequenceInterval, 0),
+		RequestsPerMinute:  env.GetOrDefaultInt(EnvRequestsPerMinute, 0),
+		MaxConcurrency:     env.GetOrDefaultInt(EnvMaxConcurrency, 0),
+		FollowCNAME:        env.GetOrDefaultBool(EnvFollowCNAME, env.GetOrDefaultBool(cname.EnvGlobalFollowCNAME, false)),
 	}
 }
 
 // DNSProvider is an implementation of the challenge.Provider interface
 type DNSProvider struct {
-	config *Config
-	client *goinwx.Client
+	config  *Config
+	client  *goinwx.Client
+	limiter *limiter.Limiter
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Dyn DNS.
@@ -63,6 +113,7 @@ func NewDNSProvider() (*DNSProvider, error) {
 	config := NewDefaultConfig()
 	config.Username = values[EnvUsername]
 	config.Password = values[EnvPassword]
+	config.SharedSecret = env.GetOrDefaultString(EnvSharedSecret, "")
 
 	return NewDNSProviderConfig(config)
 }
@@ -83,17 +134,60 @@ func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 
 	client := goinwx.NewClient(config.Username, config.Password, &goinwx.ClientOptions{Sandbox: config.Sandbox})
 
-	return &DNSProvider{config: config, client: client}, nil
+	return &DNSProvider{
+		config:  config,
+		client:  client,
+		limiter: limiter.New(config.RequestsPerMinute, config.MaxConcurrency),
+	}, nil
+}
+
+// login authenticates with the INWX API and, for accounts with
+// two-factor authentication enabled, unlocks the session with a
+// freshly computed TOTP code.
+func (d *DNSProvider) login() error {
+	err := d.client.Account.Login()
+	if err != nil {
+		return err
+	}
+
+	if d.config.SharedSecret == "" {
+		return nil
+	}
+
+	code, err := totp.GenerateCode(d.config.SharedSecret, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to generate TOTP code: %w", err)
+	}
+
+	err = d.client.Account.Unlock(code)
+	if err != nil {
+		return fmt.Errorf("failed to unlock account with TOTP code: %w", err)
+	}
+
+	return nil
 }
 
 // Present creates a TXT record using the specified parameters
 func (d *DNSProvider) Present(domain, token, fqdn, value string) error {
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("inwx: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
+		if err != nil {
+			return fmt.Errorf("inwx: %w", err)
+		}
+		fqdn = resolved
+	}
+
 	authZone, err := dns01.FindZoneByFqdn(fqdn)
 	if err != nil {
 		return fmt.Errorf("inwx: %w", err)
 	}
 
-	err = d.client.Account.Login()
+	err = d.login()
 	if err != nil {
 		return fmt.Errorf("inwx: %w", err)
 	}
@@ -131,12 +225,25 @@ func (d *DNSProvider) Present(domain, token, fqdn, value string) error {
 
 // CleanUp removes the TXT record matching the specified parameters
 func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("inwx: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
+		if err != nil {
+			return fmt.Errorf("inwx: %w", err)
+		}
+		fqdn = resolved
+	}
+
 	authZone, err := dns01.FindZoneByFqdn(fqdn)
 	if err != nil {
 		return fmt.Errorf("inwx: %w", err)
 	}
 
-	err = d.client.Account.Login()
+	err = d.login()
 	if err != nil {
 		return fmt.Errorf("inwx: %w", err)
 	}
@@ -173,3 +280,11 @@ func (d *DNSProvider) CleanUp(domain, token, fqdn, value string) error {
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
 }
+
+// Sequential causes the ACME client to solve challenges for this
+// provider one at a time instead of in parallel, waiting the returned
+// duration between each one. This is used in addition to, not instead
+// of, the rate limiter and concurrency semaphore above.
+func (d *DNSProvider) Sequential() time.Duration {
+	return d.config.SequenceInterval
+}
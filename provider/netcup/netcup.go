@@ -2,19 +2,31 @@
 package netcup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/matthiasng/dns-provider-api/provider"
+	"github.com/matthiasng/dns-provider-api/provider/internal/batcher"
+	"github.com/matthiasng/dns-provider-api/provider/internal/cname"
+	"github.com/matthiasng/dns-provider-api/provider/internal/limiter"
 	"github.com/matthiasng/dns-provider-api/provider/netcup/internal"
 
+	"github.com/go-acme/lego/v3/challenge"
 	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/log"
 	"github.com/go-acme/lego/v3/platform/config/env"
 )
 
+func init() {
+	provider.Register("netcup", func() (challenge.Provider, error) {
+		return NewDNSProvider()
+	})
+}
+
 // Environment variables names.
 const (
 	envNamespace = "NETCUP_"
@@ -27,8 +39,24 @@ const (
 	EnvPropagationTimeout = envNamespace + "PROPAGATION_TIMEOUT"
 	EnvPollingInterval    = envNamespace + "POLLING_INTERVAL"
 	EnvHTTPTimeout        = envNamespace + "HTTP_TIMEOUT"
+
+	EnvSequenceInterval  = envNamespace + "SEQUENCE_INTERVAL"
+	EnvRequestsPerMinute = envNamespace + "REQUESTS_PER_MINUTE"
+	EnvMaxConcurrency    = envNamespace + "MAX_CONCURRENCY"
+	EnvBatchWindow       = envNamespace + "BATCH_WINDOW"
+
+	EnvFollowCNAME = envNamespace + "FOLLOW_CNAME"
 )
 
+// defaultBatchWindow is how long the provider waits for concurrent
+// Present/CleanUp calls targeting the same zone to accumulate before
+// issuing a single combined updateDnsRecords call, since netcup diffs
+// the entire zone on every update. It defaults to 0 (batching disabled)
+// so a lone, non-concurrent renewal isn't taxed with a debounce delay it
+// gets no benefit from; operators issuing multi-SAN certificates can opt
+// in via NETCUP_BATCH_WINDOW.
+const defaultBatchWindow = 0
+
 // Config is used to configure the creation of the DNSProvider
 type Config struct {
 	Key                string
@@ -38,6 +66,36 @@ type Config struct {
 	PropagationTimeout time.Duration
 	PollingInterval    time.Duration
 	HTTPClient         *http.Client
+
+	// SequenceInterval is the time to wait between challenges when the
+	// ACME client solves them sequentially instead of in parallel. Note
+	// that a non-zero SequenceInterval means lego never calls
+	// Present/CleanUp concurrently, so BatchWindow will rarely see more
+	// than one record to coalesce; the two are complementary fixes for
+	// "too many requests", not additive ones, and enabling both together
+	// just adds the batch delay without a coalescing benefit.
+	SequenceInterval time.Duration
+
+	// RequestsPerMinute caps the number of JSON-RPC calls issued per
+	// minute, since netcup enforces a strict per-account request rate.
+	// A value of 0 disables rate limiting.
+	RequestsPerMinute int
+
+	// MaxConcurrency caps the number of in-flight Present/CleanUp calls.
+	// A value of 0 disables the concurrency cap.
+	MaxConcurrency int
+
+	// BatchWindow is how long concurrent Present/CleanUp calls for the
+	// same zone are buffered before being flushed as a single
+	// updateDnsRecords call. A value of 0 (the default) disables
+	// batching. Coalescing only helps when multiple calls are actually
+	// in flight together, so a low MaxConcurrency or a non-zero
+	// SequenceInterval will starve the batcher of anything to coalesce.
+	BatchWindow time.Duration
+
+	// FollowCNAME resolves fqdn's CNAME chain and solves the challenge
+	// at the terminal name instead, enabling acme-dns style delegation.
+	FollowCNAME bool
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider
@@ -49,13 +107,20 @@ func NewDefaultConfig() *Config {
 		HTTPClient: &http.Client{
 			Timeout: env.GetOrDefaultSecond(EnvHTTPTimeout, 10*time.Second),
 		},
+		SequenceInterval:  env.GetOrDefaultSecond(EnvSequenceInterval, 0),
+		RequestsPerMinute: env.GetOrDefaultInt(EnvRequestsPerMinute, 0),
+		MaxConcurrency:    env.GetOrDefaultInt(EnvMaxConcurrency, 0),
+		BatchWindow:       env.GetOrDefaultSecond(EnvBatchWindow, defaultBatchWindow),
+		FollowCNAME:       env.GetOrDefaultBool(EnvFollowCNAME, env.GetOrDefaultBool(cname.EnvGlobalFollowCNAME, false)),
 	}
 }
 
 // DNSProvider is an implementation of the challenge.Provider interface
 type DNSProvider struct {
-	client *internal.Client
-	config *Config
+	client  *internal.Client
+	config  *Config
+	limiter *limiter.Limiter
+	batcher *batcher.Batcher
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for netcup.
@@ -88,27 +153,37 @@ func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 
 	client.HTTPClient = config.HTTPClient
 
-	return &DNSProvider{client: client, config: config}, nil
+	d := &DNSProvider{
+		client:  client,
+		config:  config,
+		limiter: limiter.New(config.RequestsPerMinute, config.MaxConcurrency),
+	}
+	d.batcher = batcher.New(config.BatchWindow, d.flushZone)
+
+	return d, nil
 }
 
-// Present creates a TXT record to fulfill the dns-01 challenge
+// Present creates a TXT record to fulfill the dns-01 challenge. The
+// actual updateDnsRecords call is coalesced with other concurrent
+// Present/CleanUp calls for the same zone by d.batcher.
 func (d *DNSProvider) Present(domainName, token, fqdn, value string) error {
-	zone, err := dns01.FindZoneByFqdn(fqdn)
-	if err != nil {
-		return fmt.Errorf("netcup: failed to find DNSZone, %w", err)
-	}
-
-	sessionID, err := d.client.Login()
-	if err != nil {
+	if err := d.limiter.Wait(context.Background()); err != nil {
 		return fmt.Errorf("netcup: %w", err)
 	}
+	defer d.limiter.Done()
 
-	defer func() {
-		err = d.client.Logout(sessionID)
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
 		if err != nil {
-			log.Print("netcup: %v", err)
+			return fmt.Errorf("netcup: %w", err)
 		}
-	}()
+		fqdn = resolved
+	}
+
+	zone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return fmt.Errorf("netcup: failed to find DNSZone, %w", err)
+	}
 
 	hostname := strings.Replace(fqdn, "."+zone, "", 1)
 	record := internal.DNSRecord{
@@ -118,75 +193,150 @@ func (d *DNSProvider) Present(domainName, token, fqdn, value string) error {
 		TTL:         d.config.TTL,
 	}
 
-	zone = dns01.UnFqdn(zone)
-
-	records, err := d.client.GetDNSRecords(zone, sessionID)
-	if err != nil {
-		// skip no existing records
-		log.Infof("no existing records, error ignored: %v", err)
-	}
-
-	records = append(records, record)
-
-	err = d.client.UpdateDNSRecord(sessionID, zone, records)
-	if err != nil {
+	if err := d.batcher.Submit(dns01.UnFqdn(zone), record); err != nil {
 		return fmt.Errorf("netcup: failed to add TXT-Record: %w", err)
 	}
 
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters
+// CleanUp removes the TXT record matching the specified parameters. The
+// actual updateDnsRecords call is coalesced with other concurrent
+// Present/CleanUp calls for the same zone by d.batcher.
 func (d *DNSProvider) CleanUp(domainName, token, fqdn, value string) error {
+	if err := d.limiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("netcup: %w", err)
+	}
+	defer d.limiter.Done()
+
+	if d.config.FollowCNAME {
+		resolved, err := cname.Resolve(fqdn)
+		if err != nil {
+			return fmt.Errorf("netcup: %w", err)
+		}
+		fqdn = resolved
+	}
+
 	zone, err := dns01.FindZoneByFqdn(fqdn)
 	if err != nil {
 		return fmt.Errorf("netcup: failed to find DNSZone, %w", err)
 	}
 
+	hostname := strings.Replace(fqdn, "."+zone, "", 1)
+	record := internal.DNSRecord{
+		Hostname:     hostname,
+		RecordType:   "TXT",
+		Destination:  value,
+		DeleteRecord: true,
+	}
+
+	if err := d.batcher.Submit(dns01.UnFqdn(zone), record); err != nil {
+		return fmt.Errorf("netcup: %w", err)
+	}
+
+	return nil
+}
+
+// indexedRecord pairs a submitted record with its position in the
+// values slice flushZone was given, so a per-record failure can be
+// reported back to the right waiter without touching anyone else's.
+type indexedRecord struct {
+	idx    int
+	record internal.DNSRecord
+}
+
+// flushZone applies every record accumulated during a batch window in a
+// single UpdateDNSRecord call, logging in and out once per flush instead
+// of once per Present/CleanUp call. A delete whose record can't be found
+// only fails that one entry's result; it does not stop the adds and
+// other deletes batched into the same window from being written.
+func (d *DNSProvider) flushZone(zone string, values []interface{}) []error {
+	errs := make([]error, len(values))
+
 	sessionID, err := d.client.Login()
 	if err != nil {
-		return fmt.Errorf("netcup: %w", err)
+		return fillErr(errs, fmt.Errorf("netcup: %w", err))
 	}
 
 	defer func() {
-		err = d.client.Logout(sessionID)
-		if err != nil {
-			log.Print("netcup: %v", err)
+		if errL := d.client.Logout(sessionID); errL != nil {
+			log.Print("netcup: %v", errL)
 		}
 	}()
 
-	hostname := strings.Replace(fqdn, "."+zone, "", 1)
-
-	zone = dns01.UnFqdn(zone)
-
-	records, err := d.client.GetDNSRecords(zone, sessionID)
+	existing, err := d.client.GetDNSRecords(zone, sessionID)
 	if err != nil {
-		return fmt.Errorf("netcup: %w", err)
+		// skip no existing records
+		log.Infof("no existing records, error ignored: %v", err)
 	}
 
-	record := internal.DNSRecord{
-		Hostname:    hostname,
-		RecordType:  "TXT",
-		Destination: value,
+	var adds []indexedRecord
+	var deletes []indexedRecord
+
+	for i, v := range values {
+		record := v.(internal.DNSRecord)
+		if record.DeleteRecord {
+			deletes = append(deletes, indexedRecord{i, record})
+			continue
+		}
+		adds = append(adds, indexedRecord{i, record})
 	}
 
-	idx, err := internal.GetDNSRecordIdx(records, record)
-	if err != nil {
-		return fmt.Errorf("netcup: %w", err)
+	var validDeletes []indexedRecord
+	for _, ir := range deletes {
+		idx, err := internal.GetDNSRecordIdx(existing, ir.record)
+		if err != nil {
+			errs[ir.idx] = fmt.Errorf("netcup: %w", err)
+			continue
+		}
+		existing[idx].DeleteRecord = true
+		ir.record = existing[idx]
+		validDeletes = append(validDeletes, ir)
 	}
 
-	records[idx].DeleteRecord = true
+	var toUpdate []internal.DNSRecord
+	if len(adds) > 0 {
+		toUpdate = append(toUpdate, existing...)
+		for _, ir := range adds {
+			toUpdate = append(toUpdate, ir.record)
+		}
+	} else {
+		for _, ir := range validDeletes {
+			toUpdate = append(toUpdate, ir.record)
+		}
+	}
 
-	err = d.client.UpdateDNSRecord(sessionID, zone, []internal.DNSRecord{records[idx]})
-	if err != nil {
-		return fmt.Errorf("netcup: %w", err)
+	if err := d.client.UpdateDNSRecord(sessionID, zone, toUpdate); err != nil {
+		wrapped := fmt.Errorf("netcup: %w", err)
+		for _, ir := range adds {
+			errs[ir.idx] = wrapped
+		}
+		for _, ir := range validDeletes {
+			errs[ir.idx] = wrapped
+		}
 	}
 
-	return nil
+	return errs
+}
+
+// fillErr sets every entry of errs to err and returns it, for flush
+// failures that genuinely affect every record in the batch.
+func fillErr(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
 }
 
 // Timeout returns the timeout and interval to use when checking for DNS propagation.
 // Adjusting here to cope with spikes in propagation times.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
-}
\ No newline at end of file
+}
+
+// Sequential causes the ACME client to solve challenges for this
+// provider one at a time instead of in parallel, waiting the returned
+// duration between each one.
+func (d *DNSProvider) Sequential() time.Duration {
+	return d.config.SequenceInterval
+}
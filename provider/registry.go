@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-acme/lego/v3/challenge"
+)
+
+// Factory builds a new instance of a DNSProvider from its environment
+// variables, the same way each subpackage's NewDNSProvider does.
+type Factory func() (challenge.Provider, error)
+
+var (
+	providersMu sync.Mutex
+	providers   = make(map[string]Factory)
+)
+
+// Register makes a DNSProvider factory available under name, so it can
+// later be looked up by NewByName without the caller importing the
+// subpackage directly. Subpackages call this from their own init().
+func Register(name string, factory Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	providers[name] = factory
+}
+
+// NewByName builds the DNSProvider registered under name, using its
+// environment variables. It returns an error if no provider has been
+// registered under that name, which usually means the corresponding
+// subpackage was never imported.
+func NewByName(name string) (challenge.Provider, error) {
+	providersMu.Lock()
+	factory, ok := providers[name]
+	providersMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("provider: unrecognized DNS provider: %s", name)
+	}
+
+	return factory()
+}
@@ -0,0 +1,60 @@
+// Package provider holds the cross-cutting types shared by this module's
+// DNS providers: the StateStore abstraction used to persist per-record
+// bookkeeping across process restarts, and the provider factory registry.
+package provider
+
+import "sync"
+
+// StateStore persists the small amount of state providers need to carry
+// from Present to CleanUp (typically a record ID) across process
+// restarts. This matters in environments where the two calls can land in
+// different processes, such as a cert-manager pod being rescheduled or a
+// Traefik instance reloading between issuing a multi-SAN certificate's
+// challenges.
+type StateStore interface {
+	// Get returns the value stored for key, and false if no value has
+	// been stored (or it was deleted).
+	Get(key string) (string, bool, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// MemoryStateStore is the default StateStore: an in-process map that
+// does not survive restarts. Providers fall back to it when
+// Config.StateStore is left nil.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// NewMemoryStateStore returns an empty MemoryStateStore.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{values: make(map[string]string)}
+}
+
+// Get implements StateStore.
+func (s *MemoryStateStore) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.values[key]
+	return v, ok, nil
+}
+
+// Set implements StateStore.
+func (s *MemoryStateStore) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+	return nil
+}
+
+// Delete implements StateStore.
+func (s *MemoryStateStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package file implements provider.StateStore backed by a JSON file on
+// disk, so record IDs survive a restart without needing an external
+// dependency such as Redis.
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Store is a provider.StateStore that keeps its contents as a single
+// JSON object on disk, rewriting the whole file on every write.
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New returns a Store backed by the JSON file at path, creating an empty
+// one if it does not already exist.
+func New(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.write(map[string]string{}); err != nil {
+			return nil, fmt.Errorf("file: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Get implements provider.StateStore.
+func (s *Store) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values, err := s.read()
+	if err != nil {
+		return "", false, fmt.Errorf("file: %w", err)
+	}
+
+	v, ok := values[key]
+	return v, ok, nil
+}
+
+// Set implements provider.StateStore.
+func (s *Store) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values, err := s.read()
+	if err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+
+	values[key] = value
+
+	return s.write(values)
+}
+
+// Delete implements provider.StateStore.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values, err := s.read()
+	if err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+
+	delete(values, key)
+
+	return s.write(values)
+}
+
+func (s *Store) read() (map[string]string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func (s *Store) write(values map[string]string) error {
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path, data, 0o600)
+}
@@ -0,0 +1,53 @@
+// Package redis implements provider.StateStore backed by a Redis hash,
+// for deployments that already run Redis as shared state across multiple
+// replicas of a cert-manager webhook or ACME client.
+package redis
+
+import (
+	"fmt"
+
+	goredis "github.com/go-redis/redis/v7"
+)
+
+// Store is a provider.StateStore backed by a single Redis hash.
+type Store struct {
+	client *goredis.Client
+	hash   string
+}
+
+// New returns a Store that stores its key/value pairs in the Redis hash
+// named hash, using client to talk to Redis.
+func New(client *goredis.Client, hash string) *Store {
+	return &Store{client: client, hash: hash}
+}
+
+// Get implements provider.StateStore.
+func (s *Store) Get(key string) (string, bool, error) {
+	value, err := s.client.HGet(s.hash, key).Result()
+	if err == goredis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("redis: %w", err)
+	}
+
+	return value, true, nil
+}
+
+// Set implements provider.StateStore.
+func (s *Store) Set(key, value string) error {
+	if err := s.client.HSet(s.hash, key, value).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements provider.StateStore.
+func (s *Store) Delete(key string) error {
+	if err := s.client.HDel(s.hash, key).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+
+	return nil
+}